@@ -0,0 +1,159 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file implements ListPorts on Darwin using IOKit to find the serial
+// devices registered with the kernel and, where present, the USB device
+// each one hangs off of.
+
+package serial
+
+/*
+#cgo LDFLAGS: -framework CoreFoundation -framework IOKit
+
+#include <CoreFoundation/CoreFoundation.h>
+#include <IOKit/IOKitLib.h>
+#include <IOKit/serial/IOSerialKeys.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+func listPorts() ([]PortInfo, error) {
+	matching := C.IOServiceMatching(C.kIOSerialBSDServiceValue)
+	if matching == nil {
+		return nil, fmt.Errorf("IOServiceMatching(%s) failed", C.kIOSerialBSDServiceValue)
+	}
+
+	var iter C.io_iterator_t
+	kr := C.IOServiceGetMatchingServices(C.kIOMasterPortDefault, matching, &iter)
+	if kr != C.KERN_SUCCESS {
+		return nil, fmt.Errorf("IOServiceGetMatchingServices failed: %d", kr)
+	}
+	defer C.IOObjectRelease(iter)
+
+	var ports []PortInfo
+	for {
+		service := C.IOIteratorNext(iter)
+		if service == 0 {
+			break
+		}
+
+		info := PortInfo{
+			Name: cfStringProperty(service, C.CFSTR(C.kIOCalloutDeviceKey)),
+		}
+
+		if usb := findUSBDevice(service); usb != 0 {
+			info.VID = cfHexNumberProperty(usb, "idVendor")
+			info.PID = cfHexNumberProperty(usb, "idProduct")
+			info.SerialNumber = cfStringProperty(usb, cfstr("USB Serial Number"))
+			info.Manufacturer = cfStringProperty(usb, cfstr("USB Vendor Name"))
+			info.Description = cfStringProperty(usb, cfstr("USB Product Name"))
+			C.IOObjectRelease(usb)
+		}
+
+		C.IOObjectRelease(service)
+
+		if info.Name != "" {
+			ports = append(ports, info)
+		}
+	}
+
+	return ports, nil
+}
+
+// findUSBDevice walks up the service plane from a serial service looking for
+// the IOUSBDevice it's hanging off of, returning 0 if there isn't one (e.g.
+// a Bluetooth or built-in serial port).
+func findUSBDevice(service C.io_service_t) C.io_service_t {
+	usbClass := C.CString("IOUSBDevice")
+	defer C.free(unsafe.Pointer(usbClass))
+
+	current := service
+	C.IOObjectRetain(current)
+
+	for depth := 0; depth < 8; depth++ {
+		if C.IOObjectConformsTo(current, usbClass) != 0 {
+			return current
+		}
+
+		var parent C.io_registry_entry_t
+		kr := C.IORegistryEntryGetParentEntry(current, C.kIOServicePlane, &parent)
+		C.IOObjectRelease(current)
+
+		if kr != C.KERN_SUCCESS {
+			return 0
+		}
+
+		current = parent
+	}
+
+	C.IOObjectRelease(current)
+	return 0
+}
+
+func cfstr(s string) C.CFStringRef {
+	cs := C.CString(s)
+	defer C.free(unsafe.Pointer(cs))
+	return C.CFStringCreateWithCString(C.kCFAllocatorDefault, cs, C.kCFStringEncodingUTF8)
+}
+
+// cfStringProperty looks up a CFString-typed IOKit registry property and
+// returns it as a Go string, or "" if it's absent or a different type.
+func cfStringProperty(entry C.io_registry_entry_t, key C.CFStringRef) string {
+	prop := C.IORegistryEntryCreateCFProperty(entry, key, C.kCFAllocatorDefault, 0)
+	if prop == 0 {
+		return ""
+	}
+	defer C.CFRelease(C.CFTypeRef(prop))
+
+	ref := C.CFStringRef(prop)
+	if C.CFGetTypeID(prop) != C.CFStringGetTypeID() {
+		return ""
+	}
+
+	var buf [256]C.char
+	if C.CFStringGetCString(ref, &buf[0], C.CFIndex(len(buf)), C.kCFStringEncodingUTF8) == 0 {
+		return ""
+	}
+
+	return C.GoString(&buf[0])
+}
+
+// cfHexNumberProperty looks up a CFNumber-typed property (e.g. idVendor) and
+// formats it as a 4-digit hex string, matching how USB VID/PID are usually
+// written.
+func cfHexNumberProperty(entry C.io_registry_entry_t, key string) string {
+	cfKey := cfstr(key)
+	defer C.CFRelease(C.CFTypeRef(cfKey))
+
+	prop := C.IORegistryEntryCreateCFProperty(entry, cfKey, C.kCFAllocatorDefault, 0)
+	if prop == 0 {
+		return ""
+	}
+	defer C.CFRelease(C.CFTypeRef(prop))
+
+	if C.CFGetTypeID(prop) != C.CFNumberGetTypeID() {
+		return ""
+	}
+
+	var value C.SInt32
+	if C.CFNumberGetValue(C.CFNumberRef(prop), C.kCFNumberSInt32Type, unsafe.Pointer(&value)) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%04x", value)
+}
@@ -0,0 +1,79 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file implements ListPorts on Linux by walking /sys/class/tty, which
+// avoids a dependency on udev or its cgo bindings.
+
+package serial
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func listPorts() ([]PortInfo, error) {
+	entries, err := ioutil.ReadDir("/sys/class/tty")
+	if err != nil {
+		return nil, err
+	}
+
+	var ports []PortInfo
+	for _, entry := range entries {
+		devicePath := filepath.Join("/sys/class/tty", entry.Name(), "device")
+
+		target, err := filepath.EvalSymlinks(devicePath)
+		if err != nil {
+			// Virtual ttys (tty0, ptmx, ...) have no backing "device" link.
+			continue
+		}
+
+		info := PortInfo{Name: filepath.Join("/dev", entry.Name())}
+
+		if usbDir := findUSBDir(target); usbDir != "" {
+			info.VID = readSysAttr(usbDir, "idVendor")
+			info.PID = readSysAttr(usbDir, "idProduct")
+			info.SerialNumber = readSysAttr(usbDir, "serial")
+			info.Manufacturer = readSysAttr(usbDir, "manufacturer")
+			info.Description = readSysAttr(usbDir, "product")
+		}
+
+		ports = append(ports, info)
+	}
+
+	return ports, nil
+}
+
+// findUSBDir walks up from a tty's resolved /sys/devices path looking for
+// the ancestor directory that carries the usb_device attributes (idVendor,
+// idProduct, ...), as opposed to the tty's own USB interface subdirectory.
+func findUSBDir(devicePath string) string {
+	dir := devicePath
+	for depth := 0; depth < 8 && dir != "/" && dir != "."; depth++ {
+		if _, err := os.Stat(filepath.Join(dir, "idVendor")); err == nil {
+			return dir
+		}
+		dir = filepath.Dir(dir)
+	}
+	return ""
+}
+
+func readSysAttr(dir, name string) string {
+	b, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
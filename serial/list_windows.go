@@ -0,0 +1,168 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file implements ListPorts on Windows using SetupAPI to enumerate the
+// system's GUID_DEVCLASS_PORTS devices.
+
+package serial
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	setupapiDLL                           = syscall.NewLazyDLL("setupapi.dll")
+	procSetupDiGetClassDevsW              = setupapiDLL.NewProc("SetupDiGetClassDevsW")
+	procSetupDiEnumDeviceInfo             = setupapiDLL.NewProc("SetupDiEnumDeviceInfo")
+	procSetupDiGetDeviceRegistryPropertyW = setupapiDLL.NewProc("SetupDiGetDeviceRegistryPropertyW")
+	procSetupDiDestroyDeviceInfoList      = setupapiDLL.NewProc("SetupDiDestroyDeviceInfoList")
+)
+
+const (
+	kDIGCF_PRESENT = 0x00000002
+
+	kSPDRP_HARDWAREID   = 0x00000001
+	kSPDRP_MFG          = 0x0000000B
+	kSPDRP_FRIENDLYNAME = 0x0000000C
+)
+
+var kINVALID_HANDLE_VALUE = ^uintptr(0)
+
+// GUID_DEVCLASS_PORTS, {4d36e978-e325-11ce-bfc1-08002be10318}.
+type guid struct {
+	Data1 uint32
+	Data2 uint16
+	Data3 uint16
+	Data4 [8]byte
+}
+
+var portsClassGUID = guid{
+	0x4d36e978, 0xe325, 0x11ce,
+	[8]byte{0xbf, 0xc1, 0x08, 0x00, 0x2b, 0xe1, 0x03, 0x18},
+}
+
+type spDevinfoData struct {
+	Size      uint32
+	ClassGUID guid
+	DevInst   uint32
+	Reserved  uintptr
+}
+
+func listPorts() ([]PortInfo, error) {
+	h, _, _ :=
+		procSetupDiGetClassDevsW.Call(
+			uintptr(unsafe.Pointer(&portsClassGUID)),
+			0,
+			0,
+			uintptr(kDIGCF_PRESENT))
+
+	if h == kINVALID_HANDLE_VALUE {
+		return nil, fmt.Errorf("SetupDiGetClassDevs failed")
+	}
+	defer procSetupDiDestroyDeviceInfoList.Call(h)
+
+	var ports []PortInfo
+	for index := uint32(0); ; index++ {
+		var data spDevinfoData
+		data.Size = uint32(unsafe.Sizeof(data))
+
+		r, _, _ :=
+			procSetupDiEnumDeviceInfo.Call(
+				h,
+				uintptr(index),
+				uintptr(unsafe.Pointer(&data)))
+
+		if r == 0 {
+			break
+		}
+
+		friendlyName := getDeviceRegistryString(h, &data, kSPDRP_FRIENDLYNAME)
+
+		name := comPortFromFriendlyName(friendlyName)
+		if name == "" {
+			continue
+		}
+
+		info := PortInfo{
+			Name:         name,
+			Description:  friendlyName,
+			Manufacturer: getDeviceRegistryString(h, &data, kSPDRP_MFG),
+		}
+
+		info.VID, info.PID = parseVIDPID(getDeviceRegistryString(h, &data, kSPDRP_HARDWAREID))
+
+		ports = append(ports, info)
+	}
+
+	return ports, nil
+}
+
+// getDeviceRegistryString reads a string-valued SPDRP_* device property.
+func getDeviceRegistryString(h uintptr, data *spDevinfoData, property uint32) string {
+	var buf [512]uint16
+
+	r, _, _ :=
+		procSetupDiGetDeviceRegistryPropertyW.Call(
+			h,
+			uintptr(unsafe.Pointer(data)),
+			uintptr(property),
+			0,
+			uintptr(unsafe.Pointer(&buf[0])),
+			uintptr(len(buf)*2),
+			0)
+
+	if r == 0 {
+		return ""
+	}
+
+	return syscall.UTF16ToString(buf[:])
+}
+
+// comPortFromFriendlyName extracts "COM7" out of a friendly name like
+// "USB Serial Port (COM7)".
+func comPortFromFriendlyName(friendlyName string) string {
+	start := strings.LastIndex(friendlyName, "(COM")
+	if start == -1 {
+		return ""
+	}
+
+	end := strings.IndexByte(friendlyName[start:], ')')
+	if end == -1 {
+		return ""
+	}
+
+	return friendlyName[start+1 : start+end]
+}
+
+// parseVIDPID pulls the vendor and product IDs out of a hardware ID string
+// such as "USB\VID_2341&PID_0043&REV_0001".
+func parseVIDPID(hardwareID string) (vid, pid string) {
+	fields := strings.FieldsFunc(hardwareID, func(r rune) bool {
+		return r == '\\' || r == '&'
+	})
+
+	for _, field := range fields {
+		switch {
+		case strings.HasPrefix(field, "VID_"):
+			vid = strings.TrimPrefix(field, "VID_")
+		case strings.HasPrefix(field, "PID_"):
+			pid = strings.TrimPrefix(field, "PID_")
+		}
+	}
+
+	return vid, pid
+}
@@ -0,0 +1,88 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file implements the "mock:" backend, an in-memory stand-in for a
+// local tty that lets tests exercise protocol code without real hardware.
+
+package serial
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+var (
+	mockPortsMu sync.Mutex
+	mockPorts   = map[string]io.ReadWriteCloser{}
+)
+
+// pipePort is one end of a registered mock port: it reads from one byte pipe
+// and writes to another, so that a pair of them is full-duplex and bytes
+// written to one end are what the other end reads.
+type pipePort struct {
+	r *io.PipeReader
+	w *io.PipeWriter
+}
+
+func (p *pipePort) Read(b []byte) (int, error)  { return p.r.Read(b) }
+func (p *pipePort) Write(b []byte) (int, error) { return p.w.Write(b) }
+
+func (p *pipePort) Close() error {
+	rErr := p.r.Close()
+	wErr := p.w.Close()
+
+	if rErr != nil {
+		return rErr
+	}
+
+	return wErr
+}
+
+// NewMockPort registers an in-memory serial port under the given name and
+// returns the test-facing end of it. Whatever this end writes shows up as a
+// Read() on the port obtained via Open(OpenOptions{PortName: "mock:" +
+// name}), and vice versa. The name can only be claimed by one Open() call;
+// it's freed for reuse once that call consumes it.
+func NewMockPort(name string) (io.ReadWriteCloser, error) {
+	toTestR, toTestW := io.Pipe()
+	toPortR, toPortW := io.Pipe()
+
+	testEnd := &pipePort{r: toTestR, w: toPortW}
+	portEnd := &pipePort{r: toPortR, w: toTestW}
+
+	mockPortsMu.Lock()
+	defer mockPortsMu.Unlock()
+
+	if _, ok := mockPorts[name]; ok {
+		return nil, fmt.Errorf("a mock port named %q is already registered", name)
+	}
+
+	mockPorts[name] = portEnd
+	return testEnd, nil
+}
+
+// openMock implements the "mock:" scheme for Open.
+func openMock(name string) (io.ReadWriteCloser, error) {
+	mockPortsMu.Lock()
+	defer mockPortsMu.Unlock()
+
+	port, ok := mockPorts[name]
+	if !ok {
+		return nil, fmt.Errorf("no mock port named %q has been registered", name)
+	}
+
+	delete(mockPorts, name)
+	return port, nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"testing"
+)
+
+func TestMockPortRoundtrip(t *testing.T) {
+	testEnd, err := NewMockPort("test-roundtrip")
+	if err != nil {
+		t.Fatalf("NewMockPort: %v", err)
+	}
+	defer testEnd.Close()
+
+	portEnd, err := Open(OpenOptions{PortName: "mock:test-roundtrip"})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer portEnd.Close()
+
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := testEnd.Write([]byte("hello"))
+		writeErr <- err
+	}()
+
+	buf := make([]byte, 5)
+	if _, err := portEnd.Read(buf); err != nil {
+		t.Fatalf("Read from port end: %v", err)
+	}
+	if err := <-writeErr; err != nil {
+		t.Fatalf("Write from test end: %v", err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("got %q, want %q", buf, "hello")
+	}
+
+	go func() {
+		_, err := portEnd.Write([]byte("world"))
+		writeErr <- err
+	}()
+
+	if _, err := testEnd.Read(buf); err != nil {
+		t.Fatalf("Read from test end: %v", err)
+	}
+	if err := <-writeErr; err != nil {
+		t.Fatalf("Write from port end: %v", err)
+	}
+	if string(buf) != "world" {
+		t.Errorf("got %q, want %q", buf, "world")
+	}
+}
+
+func TestMockPortAlreadyRegistered(t *testing.T) {
+	testEnd, err := NewMockPort("test-duplicate")
+	if err != nil {
+		t.Fatalf("NewMockPort: %v", err)
+	}
+	defer testEnd.Close()
+
+	if _, err := NewMockPort("test-duplicate"); err == nil {
+		t.Errorf("expected an error registering a duplicate mock port name, got nil")
+	}
+}
+
+func TestMockPortNotRegistered(t *testing.T) {
+	if _, err := Open(OpenOptions{PortName: "mock:no-such-port"}); err == nil {
+		t.Errorf("expected an error opening an unregistered mock port, got nil")
+	}
+}
+
+func TestMockPortConsumedOnOpen(t *testing.T) {
+	testEnd, err := NewMockPort("test-consumed")
+	if err != nil {
+		t.Fatalf("NewMockPort: %v", err)
+	}
+	defer testEnd.Close()
+
+	portEnd, err := Open(OpenOptions{PortName: "mock:test-consumed"})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer portEnd.Close()
+
+	if _, err := Open(OpenOptions{PortName: "mock:test-consumed"}); err == nil {
+		t.Errorf("expected an error opening an already-consumed mock port, got nil")
+	}
+}
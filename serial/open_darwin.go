@@ -41,16 +41,19 @@ type tcflag_t uint64
 
 // sys/termios.h
 const (
-	kCS5    = 0x00000000
-	kCS6    = 0x00000100
-	kCS7    = 0x00000200
-	kCS8    = 0x00000300
-	kCLOCAL = 0x00008000
-	kCREAD  = 0x00000800
-	kCSTOPB = 0x00000400
-	kIGNPAR = 0x00000004
-	kPARENB = 0x00001000
-	kPARODD = 0x00002000
+	kCS5     = 0x00000000
+	kCS6     = 0x00000100
+	kCS7     = 0x00000200
+	kCS8     = 0x00000300
+	kCLOCAL  = 0x00008000
+	kCREAD   = 0x00000800
+	kCSTOPB  = 0x00000400
+	kIGNPAR  = 0x00000004
+	kPARENB  = 0x00001000
+	kPARODD  = 0x00002000
+	kIXON    = 0x00000200
+	kIXOFF   = 0x00000400
+	kCRTSCTS = 0x00030000
 
 	kNCCS = 20
 
@@ -62,8 +65,36 @@ const (
 const (
 	kTIOCGETA = 1078490131
 	kTIOCSETA = 2152231956
+
+	kTIOCMGET = 0x4004746a
+	kTIOCMSET = 0x8004746d
+	kTIOCMBIS = 0x8004746c
+	kTIOCMBIC = 0x8004746b
+)
+
+// sys/ttycom.h modem status bits
+const (
+	kTIOCM_DTR = 0x002
+	kTIOCM_RTS = 0x004
+	kTIOCM_CTS = 0x020
+	kTIOCM_DSR = 0x100
+	kTIOCM_CD  = 0x040
+	kTIOCM_RI  = 0x080
 )
 
+// sys/ioctl.h. IOSSIOSPEED is _IOW('T', 2, speed_t) and lets us apply a baud
+// rate that TIOCSETA's standard-rate validation would otherwise reject.
+const kIOSSIOSPEED = 0x80085402
+
+// standardBaudRates is the set of rates that TIOCSETA accepts directly.
+// Anything else needs the IOSSIOSPEED workaround below.
+var standardBaudRates = map[uint]bool{
+	50: true, 75: true, 110: true, 134: true, 150: true, 200: true,
+	300: true, 600: true, 1200: true, 1800: true, 2400: true, 4800: true,
+	7200: true, 9600: true, 14400: true, 19200: true, 28800: true,
+	38400: true, 57600: true, 76800: true, 115200: true, 230400: true,
+}
+
 // sys/termios.h
 type termios struct {
 	c_iflag  tcflag_t
@@ -75,29 +106,49 @@ type termios struct {
 	c_ospeed speed_t
 }
 
+// controlFd runs f with the raw fd underlying file, via SyscallConn rather
+// than file.Fd(). Calling file.Fd() directly would force the file out of
+// non-blocking mode for good, which is what lets the runtime's poller back
+// SetReadDeadline/SetWriteDeadline and an unblocking Close(); SyscallConn
+// gets us the fd for the duration of the ioctl without that side effect.
+func controlFd(file *os.File, f func(fd uintptr)) error {
+	conn, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return conn.Control(f)
+}
+
 // setTermios updates the termios struct associated with a serial port file
 // descriptor. This sets appropriate options for how the OS interacts with the
 // port.
-func setTermios(fd int, src *termios) error {
-	// Make the ioctl syscall that sets the termios struct.
-	r1, _, errno :=
-		syscall.Syscall(
-			syscall.SYS_IOCTL,
-			uintptr(fd),
-			uintptr(kTIOCSETA),
-			uintptr(unsafe.Pointer(src)))
-
-	// Did the syscall return an error?
-	if err := os.NewSyscallError("SYS_IOCTL", int(errno)); err != nil {
+func setTermios(file *os.File, src *termios) error {
+	var ioctlErr error
+	err := controlFd(file, func(fd uintptr) {
+		// Make the ioctl syscall that sets the termios struct.
+		r1, _, errno :=
+			syscall.Syscall(
+				syscall.SYS_IOCTL,
+				fd,
+				uintptr(kTIOCSETA),
+				uintptr(unsafe.Pointer(src)))
+
+		// Did the syscall return an error?
+		if err := os.NewSyscallError("SYS_IOCTL", errno); err != nil {
+			ioctlErr = err
+			return
+		}
+
+		// Just in case, check the return value as well.
+		if r1 != 0 {
+			ioctlErr = errors.New("Unknown error from SYS_IOCTL.")
+		}
+	})
+	if err != nil {
 		return err
 	}
 
-	// Just in case, check the return value as well.
-	if r1 != 0 {
-		return errors.New("Unknown error from SYS_IOCTL.")
-	}
-
-	return nil
+	return ioctlErr
 }
 
 func round(f float64) float64 {
@@ -133,31 +184,11 @@ func convertOptions(options OpenOptions) (*termios, error) {
 	result.c_cc[kVTIME] = cc_t(vtime / 100)
 	result.c_cc[kVMIN] = cc_t(vmin)
 
-	// Baud rate
-	switch options.BaudRate {
-	case 50:
-	case 75:
-	case 110:
-	case 134:
-	case 150:
-	case 200:
-	case 300:
-	case 600:
-	case 1200:
-	case 1800:
-	case 2400:
-	case 4800:
-	case 7200:
-	case 9600:
-	case 14400:
-	case 19200:
-	case 28800:
-	case 38400:
-	case 57600:
-	case 76800:
-	case 115200:
-	case 230400:
-	default:
+	// Baud rate. Unlike Linux, OS X doesn't restrict us to a
+	// small set of standard rates at this layer; non-standard rates (e.g. for
+	// GPS modules, radios, or MIDI) are applied afterwards in openInternal via
+	// the IOSSIOSPEED ioctl.
+	if options.BaudRate < 50 {
 		return nil, errors.New("Invalid setting for BaudRate.")
 	}
 
@@ -209,9 +240,117 @@ func convertOptions(options OpenOptions) (*termios, error) {
 		return nil, errors.New("Invalid setting for ParityMode.")
 	}
 
+	// Flow control
+	switch options.FlowControl {
+	case FLOW_NONE:
+		// Nothing to do.
+	case FLOW_HARDWARE:
+		result.c_cflag |= kCRTSCTS
+	case FLOW_SOFTWARE:
+		result.c_iflag |= kIXON | kIXOFF
+	default:
+		return nil, errors.New("Invalid setting for FlowControl.")
+	}
+
 	return &result, nil
 }
 
+// port wraps the *os.File returned by opening a serial device on Darwin,
+// retaining access to its file descriptor so that we can issue the modem
+// control ioctls that os.File itself doesn't expose.
+type port struct {
+	*os.File
+}
+
+// setModemBit asserts or deasserts a single TIOCM_* bit via TIOCMBIS/TIOCMBIC,
+// leaving the other modem control lines untouched.
+func (p *port) setModemBit(bit int32, enable bool) error {
+	ioctl := uintptr(kTIOCMBIC)
+	if enable {
+		ioctl = uintptr(kTIOCMBIS)
+	}
+
+	var ioctlErr error
+	err := controlFd(p.File, func(fd uintptr) {
+		r1, _, errno :=
+			syscall.Syscall(
+				syscall.SYS_IOCTL,
+				fd,
+				ioctl,
+				uintptr(unsafe.Pointer(&bit)))
+
+		if err := os.NewSyscallError("SYS_IOCTL", errno); err != nil {
+			ioctlErr = err
+			return
+		}
+
+		if r1 != 0 {
+			ioctlErr = errors.New("Unknown error from SYS_IOCTL.")
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return ioctlErr
+}
+
+// SetDTR asserts or deasserts the DTR line.
+func (p *port) SetDTR(enable bool) error {
+	return p.setModemBit(kTIOCM_DTR, enable)
+}
+
+// SetRTS asserts or deasserts the RTS line.
+func (p *port) SetRTS(enable bool) error {
+	return p.setModemBit(kTIOCM_RTS, enable)
+}
+
+// GetModemStatus returns the current state of the DSR, CTS, DCD and RI lines.
+func (p *port) GetModemStatus() (ModemStatusBits, error) {
+	var bits int32
+
+	var ioctlErr error
+	err := controlFd(p.File, func(fd uintptr) {
+		r1, _, errno :=
+			syscall.Syscall(
+				syscall.SYS_IOCTL,
+				fd,
+				uintptr(kTIOCMGET),
+				uintptr(unsafe.Pointer(&bits)))
+
+		if err := os.NewSyscallError("SYS_IOCTL", errno); err != nil {
+			ioctlErr = err
+			return
+		}
+
+		if r1 != 0 {
+			ioctlErr = errors.New("Unknown error from SYS_IOCTL.")
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	if ioctlErr != nil {
+		return 0, ioctlErr
+	}
+
+	var result ModemStatusBits
+	if bits&kTIOCM_DSR != 0 {
+		result |= STATUS_DSR
+	}
+	if bits&kTIOCM_CTS != 0 {
+		result |= STATUS_CTS
+	}
+	if bits&kTIOCM_CD != 0 {
+		result |= STATUS_DCD
+	}
+	if bits&kTIOCM_RI != 0 {
+		result |= STATUS_RI
+	}
+
+	return result, nil
+}
+
 func openInternal(options OpenOptions) (io.ReadWriteCloser, error) {
 	// Open the serial port in non-blocking mode, since otherwise the OS will
 	// wait for the CARRIER line to be asserted.
@@ -225,33 +364,63 @@ func openInternal(options OpenOptions) (io.ReadWriteCloser, error) {
 		return nil, err
 	}
 
-	// We want to do blocking I/O, so clear the non-blocking flag set above.
-	r1, _, errno :=
-		syscall.Syscall(
-			syscall.SYS_FCNTL,
-			uintptr(file.Fd()),
-			uintptr(syscall.F_SETFL),
-			uintptr(0))
+	// Leave the fd in non-blocking mode rather than clearing O_NONBLOCK as we
+	// used to. This lets the runtime's poller own the fd, which in turn is
+	// what makes *os.File's SetReadDeadline/SetWriteDeadline work on it and
+	// lets a Close() from another goroutine promptly unblock a pending Read().
+	// Reads and writes still behave as blocking calls from the caller's
+	// perspective; the non-blocking flag only changes who waits for
+	// readiness.
 
-	if err := os.NewSyscallError("SYS_IOCTL", int(errno)); err != nil {
+	// Set appropriate options.
+	terminalOptions, err := convertOptions(options)
+	if err != nil {
 		return nil, err
 	}
 
-	if r1 != 0 {
-		return nil, errors.New("Unknown error from SYS_FCNTL.")
+	// TIOCSETA rejects rates outside standardBaudRates, so hand it a standard
+	// placeholder rate; IOSSIOSPEED below then applies the requested one.
+	if !standardBaudRates[options.BaudRate] {
+		terminalOptions.c_ispeed = 9600
+		terminalOptions.c_ospeed = 9600
 	}
 
-	// Set appropriate options.
-	terminalOptions, err := convertOptions(options)
+	err = setTermios(file, terminalOptions)
 	if err != nil {
 		return nil, err
 	}
 
-	err = setTermios(file.Fd(), terminalOptions)
-	if err != nil {
-		return nil, err
+	// TIOCSETA above only understands a fixed set of standard rates. For
+	// anything else, apply the requested speed with IOSSIOSPEED.
+	if !standardBaudRates[options.BaudRate] {
+		speed := speed_t(options.BaudRate)
+
+		var ioctlErr error
+		err := controlFd(file, func(fd uintptr) {
+			r1, _, errno :=
+				syscall.Syscall(
+					syscall.SYS_IOCTL,
+					fd,
+					uintptr(kIOSSIOSPEED),
+					uintptr(unsafe.Pointer(&speed)))
+
+			if err := os.NewSyscallError("SYS_IOCTL", errno); err != nil {
+				ioctlErr = err
+				return
+			}
+
+			if r1 != 0 {
+				ioctlErr = errors.New("Unknown error from SYS_IOCTL.")
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		if ioctlErr != nil {
+			return nil, ioctlErr
+		}
 	}
 
 	// We're done.
-	return file, nil
+	return &port{file}, nil
 }
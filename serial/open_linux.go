@@ -0,0 +1,332 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file contains OS-specific constants and types that work on Linux.
+
+package serial
+
+import (
+	"errors"
+	"io"
+)
+import "math"
+import "os"
+import "syscall"
+import "unsafe"
+
+// termios types
+type cc_t byte
+type speed_t uint32
+type tcflag_t uint32
+
+// asm-generic/termbits.h
+const (
+	kCS5     = 0x00000000
+	kCS6     = 0x00000010
+	kCS7     = 0x00000020
+	kCS8     = 0x00000030
+	kCLOCAL  = 0x00000800
+	kCREAD   = 0x00000080
+	kCSTOPB  = 0x00000040
+	kIGNPAR  = 0x00000004
+	kPARENB  = 0x00000100
+	kPARODD  = 0x00000200
+	kIXON    = 0x00000400
+	kIXOFF   = 0x00001000
+	kCRTSCTS = 0x80000000
+
+	kNCCS = 19
+
+	kVMIN  = tcflag_t(6)
+	kVTIME = tcflag_t(5)
+)
+
+// asm-generic/ioctls.h
+const (
+	kTCGETS = 0x5401
+	kTCSETS = 0x5402
+
+	// kTCSETS2 applies a termios2 struct, which carries its own c_ispeed and
+	// c_ospeed fields and so can set rates the Bxxx constants don't cover.
+	kTCSETS2 = 0x402c542b
+)
+
+// asm-generic/termbits.h. BOTHER tells the kernel to take the rate from
+// termios2's c_ispeed/c_ospeed fields rather than from a Bxxx constant.
+const kBOTHER = 0x1000
+
+// asm-generic/termbits.h
+type termios struct {
+	c_iflag tcflag_t
+	c_oflag tcflag_t
+	c_cflag tcflag_t
+	c_lflag tcflag_t
+	c_line  cc_t
+	c_cc    [kNCCS]cc_t
+}
+
+// asm-generic/termbits.h. Same as termios, but with the c_ispeed/c_ospeed
+// fields needed to set a rate via BOTHER.
+type termios2 struct {
+	c_iflag  tcflag_t
+	c_oflag  tcflag_t
+	c_cflag  tcflag_t
+	c_lflag  tcflag_t
+	c_line   cc_t
+	c_cc     [kNCCS]cc_t
+	c_ispeed speed_t
+	c_ospeed speed_t
+}
+
+// controlFd runs f with the raw fd underlying file, via SyscallConn rather
+// than file.Fd(). Calling file.Fd() directly would force the file out of
+// non-blocking mode for good, which is what lets the runtime's poller back
+// SetReadDeadline/SetWriteDeadline and an unblocking Close(); SyscallConn
+// gets us the fd for the duration of the ioctl without that side effect.
+func controlFd(file *os.File, f func(fd uintptr)) error {
+	conn, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return conn.Control(f)
+}
+
+// setTermios updates the termios struct associated with a serial port file
+// descriptor. This sets appropriate options for how the OS interacts with the
+// port.
+func setTermios(file *os.File, src *termios) error {
+	var ioctlErr error
+	err := controlFd(file, func(fd uintptr) {
+		// Make the ioctl syscall that sets the termios struct.
+		r1, _, errno :=
+			syscall.Syscall(
+				syscall.SYS_IOCTL,
+				fd,
+				uintptr(kTCSETS),
+				uintptr(unsafe.Pointer(src)))
+
+		// Did the syscall return an error?
+		if err := os.NewSyscallError("SYS_IOCTL", errno); err != nil {
+			ioctlErr = err
+			return
+		}
+
+		// Just in case, check the return value as well.
+		if r1 != 0 {
+			ioctlErr = errors.New("Unknown error from SYS_IOCTL.")
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return ioctlErr
+}
+
+// setTermios2 applies a termios2 struct carrying an arbitrary baud rate via
+// TCSETS2, for rates that don't have a corresponding Bxxx constant.
+func setTermios2(file *os.File, src *termios, baudRate uint) error {
+	t2 := termios2{
+		c_iflag:  src.c_iflag,
+		c_oflag:  src.c_oflag,
+		c_cflag:  src.c_cflag,
+		c_lflag:  src.c_lflag,
+		c_line:   src.c_line,
+		c_cc:     src.c_cc,
+		c_ispeed: speed_t(baudRate),
+		c_ospeed: speed_t(baudRate),
+	}
+
+	var ioctlErr error
+	err := controlFd(file, func(fd uintptr) {
+		r1, _, errno :=
+			syscall.Syscall(
+				syscall.SYS_IOCTL,
+				fd,
+				uintptr(kTCSETS2),
+				uintptr(unsafe.Pointer(&t2)))
+
+		if err := os.NewSyscallError("SYS_IOCTL", errno); err != nil {
+			ioctlErr = err
+			return
+		}
+
+		if r1 != 0 {
+			ioctlErr = errors.New("Unknown error from SYS_IOCTL.")
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return ioctlErr
+}
+
+func round(f float64) float64 {
+	return math.Floor(f + 0.5)
+}
+
+// standardBaudRates maps the baud rates we accept to the Bxxx constants that
+// Linux expects to find packed into the low bits of c_cflag.
+var standardBaudRates = map[uint]tcflag_t{
+	50:     0x0001,
+	75:     0x0002,
+	110:    0x0003,
+	134:    0x0004,
+	150:    0x0005,
+	200:    0x0006,
+	300:    0x0007,
+	600:    0x0008,
+	1200:   0x0009,
+	1800:   0x000a,
+	2400:   0x000b,
+	4800:   0x000c,
+	9600:   0x000d,
+	19200:  0x000e,
+	38400:  0x000f,
+	57600:  0x1001,
+	115200: 0x1002,
+	230400: 0x1003,
+}
+
+func convertOptions(options OpenOptions) (*termios, error) {
+	var result termios
+
+	// Ignore modem status lines. We don't want to receive SIGHUP when the serial
+	// port is disconnected, for example.
+	result.c_cflag |= kCLOCAL
+
+	// Enable receiving data.
+	result.c_cflag |= kCREAD
+
+	// Sanity check inter-character timeout and minimum read size options.
+	vtime := uint(round(float64(options.InterCharacterTimeout)/100.0) * 100)
+	vmin := options.MinimumReadSize
+
+	if vmin == 0 && vtime < 100 {
+		return nil, errors.New("Invalid values for InterCharacterTimeout and MinimumReadSize.")
+	}
+
+	if vtime > 25500 {
+		return nil, errors.New("Invalid value for InterCharacterTimeout.")
+	}
+
+	// Set VMIN and VTIME. Make sure to convert to tenths of seconds for VTIME.
+	result.c_cc[kVMIN] = cc_t(vmin)
+	result.c_cc[kVTIME] = cc_t(vtime / 100)
+
+	// Baud rate. On Linux the speed is packed into c_cflag via one of the Bxxx
+	// constants rather than being set directly, as on OS X. Rates outside that
+	// fixed set are requested with BOTHER; openInternal then applies the exact
+	// rate via the termios2/TCSETS2 interface instead of plain TCSETS.
+	if baud, ok := standardBaudRates[options.BaudRate]; ok {
+		result.c_cflag |= baud
+	} else if options.BaudRate >= 50 {
+		result.c_cflag |= kBOTHER
+	} else {
+		return nil, errors.New("Invalid setting for BaudRate.")
+	}
+
+	// Data bits
+	switch options.DataBits {
+	case 5:
+		result.c_cflag |= kCS5
+	case 6:
+		result.c_cflag |= kCS6
+	case 7:
+		result.c_cflag |= kCS7
+	case 8:
+		result.c_cflag |= kCS8
+	default:
+		return nil, errors.New("Invalid setting for DataBits.")
+	}
+
+	// Stop bits
+	switch options.StopBits {
+	case 1:
+		// Nothing to do; CSTOPB is already cleared.
+	case 2:
+		result.c_cflag |= kCSTOPB
+	default:
+		return nil, errors.New("Invalid setting for StopBits.")
+	}
+
+	// Parity mode
+	switch options.ParityMode {
+	case PARITY_NONE:
+		// Nothing to do; PARENB is already not set.
+	case PARITY_ODD:
+		result.c_cflag |= kPARENB
+		result.c_cflag |= kPARODD
+	case PARITY_EVEN:
+		result.c_cflag |= kPARENB
+	default:
+		return nil, errors.New("Invalid setting for ParityMode.")
+	}
+
+	// Flow control
+	switch options.FlowControl {
+	case FLOW_NONE:
+		// Nothing to do.
+	case FLOW_HARDWARE:
+		result.c_cflag |= kCRTSCTS
+	case FLOW_SOFTWARE:
+		result.c_iflag |= kIXON | kIXOFF
+	default:
+		return nil, errors.New("Invalid setting for FlowControl.")
+	}
+
+	return &result, nil
+}
+
+func openInternal(options OpenOptions) (io.ReadWriteCloser, error) {
+	// Open the serial port in non-blocking mode, since otherwise the OS will
+	// wait for the CARRIER line to be asserted.
+	file, err :=
+		os.OpenFile(
+			options.PortName,
+			os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK,
+			0600)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Leave the fd in non-blocking mode rather than clearing O_NONBLOCK as we
+	// used to. This lets the runtime's poller own the fd, which in turn is
+	// what makes *os.File's SetReadDeadline/SetWriteDeadline work on it and
+	// lets a Close() from another goroutine promptly unblock a pending Read().
+	// Reads and writes still behave as blocking calls from the caller's
+	// perspective; the non-blocking flag only changes who waits for
+	// readiness.
+
+	// Set appropriate options.
+	terminalOptions, err := convertOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := standardBaudRates[options.BaudRate]; ok {
+		err = setTermios(file, terminalOptions)
+	} else {
+		err = setTermios2(file, terminalOptions, options.BaudRate)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// We're done.
+	return file, nil
+}
@@ -0,0 +1,219 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file contains OS-specific constants and types that work on Windows.
+
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32DLL         = syscall.NewLazyDLL("kernel32.dll")
+	procSetCommState    = kernel32DLL.NewProc("SetCommState")
+	procSetCommTimeouts = kernel32DLL.NewProc("SetCommTimeouts")
+)
+
+// winbase.h
+const (
+	kDTR_CONTROL_ENABLE    = 1
+	kRTS_CONTROL_ENABLE    = 1
+	kRTS_CONTROL_HANDSHAKE = 2
+
+	kNOPARITY   = 0
+	kODDPARITY  = 1
+	kEVENPARITY = 2
+
+	kONESTOPBIT  = 0
+	kTWOSTOPBITS = 2
+
+	kFBinary          = 1 << 0
+	kFParity          = 1 << 1
+	kFOutxCtsFlow     = 1 << 2
+	kFDtrControlShift = 4
+	kFOutX            = 1 << 8
+	kFInX             = 1 << 9
+	kFRtsControlShift = 12
+)
+
+// winbase.h DCB, with the full layout SetCommState expects. The bitfields packed after
+// BaudRate are represented as a single Flags word that we manipulate by hand.
+type dcb struct {
+	DCBlength  uint32
+	BaudRate   uint32
+	Flags      uint32
+	wReserved  uint16
+	XonLim     uint16
+	XoffLim    uint16
+	ByteSize   byte
+	Parity     byte
+	StopBits   byte
+	XonChar    byte
+	XoffChar   byte
+	ErrorChar  byte
+	EofChar    byte
+	EvtChar    byte
+	wReserved1 uint16
+}
+
+// winbase.h COMMTIMEOUTS
+type commTimeouts struct {
+	ReadIntervalTimeout         uint32
+	ReadTotalTimeoutMultiplier  uint32
+	ReadTotalTimeoutConstant    uint32
+	WriteTotalTimeoutMultiplier uint32
+	WriteTotalTimeoutConstant   uint32
+}
+
+func convertOptions(options OpenOptions) (*dcb, error) {
+	var result dcb
+	result.DCBlength = uint32(unsafe.Sizeof(result))
+	result.BaudRate = uint32(options.BaudRate)
+
+	// Enable binary mode and leave DTR/RTS asserted so the device stays
+	// powered up the way it would with a direct local connection.
+	result.Flags |= kFBinary
+	result.Flags |= kDTR_CONTROL_ENABLE << kFDtrControlShift
+	result.Flags |= kRTS_CONTROL_ENABLE << kFRtsControlShift
+
+	switch options.DataBits {
+	case 5, 6, 7, 8:
+		result.ByteSize = byte(options.DataBits)
+	default:
+		return nil, errors.New("Invalid setting for DataBits.")
+	}
+
+	switch options.StopBits {
+	case 1:
+		result.StopBits = kONESTOPBIT
+	case 2:
+		result.StopBits = kTWOSTOPBITS
+	default:
+		return nil, errors.New("Invalid setting for StopBits.")
+	}
+
+	switch options.ParityMode {
+	case PARITY_NONE:
+		result.Parity = kNOPARITY
+	case PARITY_ODD:
+		result.Flags |= kFParity
+		result.Parity = kODDPARITY
+	case PARITY_EVEN:
+		result.Flags |= kFParity
+		result.Parity = kEVENPARITY
+	default:
+		return nil, errors.New("Invalid setting for ParityMode.")
+	}
+
+	switch options.FlowControl {
+	case FLOW_NONE:
+		// Nothing to do.
+	case FLOW_HARDWARE:
+		result.Flags |= kFOutxCtsFlow
+		result.Flags &^= 3 << kFRtsControlShift
+		result.Flags |= kRTS_CONTROL_HANDSHAKE << kFRtsControlShift
+	case FLOW_SOFTWARE:
+		result.Flags |= kFOutX | kFInX
+	default:
+		return nil, errors.New("Invalid setting for FlowControl.")
+	}
+
+	return &result, nil
+}
+
+// setCommState pushes a DCB struct to the given handle via SetCommState.
+func setCommState(handle syscall.Handle, src *dcb) error {
+	r1, _, err := procSetCommState.Call(
+		uintptr(handle),
+		uintptr(unsafe.Pointer(src)))
+
+	if r1 == 0 {
+		return fmt.Errorf("SetCommState: %v", err)
+	}
+
+	return nil
+}
+
+// setCommTimeouts configures the handle's read timeouts. When MinimumReadSize
+// is 0, InterCharacterTimeout milliseconds (at least 1) is used as the read
+// interval timeout, so a read returns once that much time passes between
+// bytes or the buffer is full. When MinimumReadSize is nonzero no timeouts are
+// set and a read waits until the buffer is full; MinimumReadSize itself is
+// not applied.
+func setCommTimeouts(handle syscall.Handle, options OpenOptions) error {
+	var timeouts commTimeouts
+
+	if options.MinimumReadSize == 0 {
+		timeouts.ReadIntervalTimeout = uint32(options.InterCharacterTimeout)
+		if timeouts.ReadIntervalTimeout == 0 {
+			timeouts.ReadIntervalTimeout = 1
+		}
+	}
+
+	r1, _, err := procSetCommTimeouts.Call(
+		uintptr(handle),
+		uintptr(unsafe.Pointer(&timeouts)))
+
+	if r1 == 0 {
+		return fmt.Errorf("SetCommTimeouts: %v", err)
+	}
+
+	return nil
+}
+
+func openInternal(options OpenOptions) (io.ReadWriteCloser, error) {
+	portName, err := syscall.UTF16PtrFromString("\\\\.\\" + options.PortName)
+	if err != nil {
+		return nil, err
+	}
+
+	handle, err :=
+		syscall.CreateFile(
+			portName,
+			syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+			0,
+			nil,
+			syscall.OPEN_EXISTING,
+			0,
+			0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	deviceOptions, err := convertOptions(options)
+	if err != nil {
+		syscall.CloseHandle(handle)
+		return nil, err
+	}
+
+	if err := setCommState(handle, deviceOptions); err != nil {
+		syscall.CloseHandle(handle)
+		return nil, err
+	}
+
+	if err := setCommTimeouts(handle, options); err != nil {
+		syscall.CloseHandle(handle)
+		return nil, err
+	}
+
+	// We're done.
+	return os.NewFile(uintptr(handle), options.PortName), nil
+}
@@ -0,0 +1,332 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file implements the "rfc2217://" backend, which speaks the Telnet
+// Com Port Control Option (RFC 2217) to a serial-over-IP device server
+// instead of talking to a local tty.
+
+package serial
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Telnet command bytes (RFC 854).
+const (
+	tnIAC  byte = 255
+	tnDONT byte = 254
+	tnDO   byte = 253
+	tnWONT byte = 252
+	tnWILL byte = 251
+	tnSB   byte = 250
+	tnSE   byte = 240
+
+	comPortOption byte = 44
+)
+
+// RFC 2217 client-to-server subnegotiation commands.
+const (
+	rfcSetBaudRate      = 1
+	rfcSetDataSize      = 2
+	rfcSetParity        = 3
+	rfcSetStopSize      = 4
+	rfcSetControl       = 5
+	rfcNotifyModemState = 7
+)
+
+// RFC 2217 SET-CONTROL values we send.
+const (
+	rfcControlFlowNone     = 1
+	rfcControlFlowXonXoff  = 2
+	rfcControlFlowHardware = 3
+	rfcControlDtrOn        = 8
+	rfcControlDtrOff       = 9
+	rfcControlRtsOn        = 11
+	rfcControlRtsOff       = 12
+)
+
+// rfc2217Port is an io.ReadWriteCloser backed by a TCP connection to an
+// RFC 2217 device server. It implements Port, translating SetDTR/SetRTS into
+// SET-CONTROL subnegotiations and tracking the most recent
+// NOTIFY-MODEMSTATE sent by the server for GetModemStatus.
+type rfc2217Port struct {
+	conn net.Conn
+	in   *io.PipeReader
+	inW  *io.PipeWriter
+
+	mu         sync.Mutex
+	modemState ModemStatusBits
+}
+
+// openRFC2217 implements the "rfc2217://" scheme for Open.
+func openRFC2217(options OpenOptions) (io.ReadWriteCloser, error) {
+	addr := strings.TrimPrefix(options.PortName, "rfc2217://")
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	inR, inW := io.Pipe()
+	p := &rfc2217Port{conn: conn, in: inR, inW: inW}
+
+	go p.demux()
+
+	if err := p.negotiate(options); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// negotiate offers the COM-PORT-OPTION and pushes the requested port
+// settings to the server as SET-* subnegotiations.
+func (p *rfc2217Port) negotiate(options OpenOptions) error {
+	if _, err := p.conn.Write([]byte{tnIAC, tnWILL, comPortOption}); err != nil {
+		return err
+	}
+
+	baud := uint32(options.BaudRate)
+	err := p.sendSub(rfcSetBaudRate,
+		byte(baud>>24), byte(baud>>16), byte(baud>>8), byte(baud))
+	if err != nil {
+		return err
+	}
+
+	if err := p.sendSub(rfcSetDataSize, byte(options.DataBits)); err != nil {
+		return err
+	}
+
+	var parity byte
+	switch options.ParityMode {
+	case PARITY_NONE:
+		parity = 1
+	case PARITY_ODD:
+		parity = 2
+	case PARITY_EVEN:
+		parity = 3
+	default:
+		return fmt.Errorf("invalid setting for ParityMode")
+	}
+	if err := p.sendSub(rfcSetParity, parity); err != nil {
+		return err
+	}
+
+	var stopSize byte
+	switch options.StopBits {
+	case 1:
+		stopSize = 1
+	case 2:
+		stopSize = 2
+	default:
+		return fmt.Errorf("invalid setting for StopBits")
+	}
+	if err := p.sendSub(rfcSetStopSize, stopSize); err != nil {
+		return err
+	}
+
+	var flow byte
+	switch options.FlowControl {
+	case FLOW_NONE:
+		flow = rfcControlFlowNone
+	case FLOW_SOFTWARE:
+		flow = rfcControlFlowXonXoff
+	case FLOW_HARDWARE:
+		flow = rfcControlFlowHardware
+	default:
+		return fmt.Errorf("invalid setting for FlowControl")
+	}
+
+	return p.sendSub(rfcSetControl, flow)
+}
+
+// sendSub writes an IAC SB COM-PORT-OPTION <cmd> <data...> IAC SE
+// subnegotiation, escaping any literal 0xFF bytes in data.
+func (p *rfc2217Port) sendSub(cmd byte, data ...byte) error {
+	buf := []byte{tnIAC, tnSB, comPortOption, cmd}
+	for _, b := range data {
+		if b == tnIAC {
+			buf = append(buf, tnIAC)
+		}
+		buf = append(buf, b)
+	}
+	buf = append(buf, tnIAC, tnSE)
+
+	_, err := p.conn.Write(buf)
+	return err
+}
+
+// demux reads the Telnet stream off the connection, stripping out IAC
+// commands (including asynchronous NOTIFY-MODEMSTATE subnegotiations) and
+// writing the remaining plain serial data to the pipe Read() serves from.
+func (p *rfc2217Port) demux() {
+	r := bufio.NewReader(p.conn)
+
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			p.inW.CloseWithError(err)
+			return
+		}
+
+		if b != tnIAC {
+			if _, err := p.inW.Write([]byte{b}); err != nil {
+				return
+			}
+			continue
+		}
+
+		cmd, err := r.ReadByte()
+		if err != nil {
+			p.inW.CloseWithError(err)
+			return
+		}
+
+		switch cmd {
+		case tnIAC:
+			// An escaped literal 0xFF data byte.
+			if _, err := p.inW.Write([]byte{tnIAC}); err != nil {
+				return
+			}
+
+		case tnWILL, tnWONT, tnDO, tnDONT:
+			// Consume the option byte. We only ever offer COM-PORT-OPTION
+			// ourselves and don't bother negotiating anything the server
+			// offers us, so there's nothing to act on here.
+			if _, err := r.ReadByte(); err != nil {
+				p.inW.CloseWithError(err)
+				return
+			}
+
+		case tnSB:
+			if err := p.handleSubnegotiation(r); err != nil {
+				p.inW.CloseWithError(err)
+				return
+			}
+		}
+	}
+}
+
+// handleSubnegotiation consumes an IAC SB ... IAC SE block, updating
+// modemState when it carries a NOTIFY-MODEMSTATE from the server.
+func (p *rfc2217Port) handleSubnegotiation(r *bufio.Reader) error {
+	option, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	var payload []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		if b == tnIAC {
+			next, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+			if next == tnSE {
+				break
+			}
+			payload = append(payload, tnIAC)
+			continue
+		}
+
+		payload = append(payload, b)
+	}
+
+	if option == comPortOption && len(payload) >= 2 && payload[0] == rfcNotifyModemState+100 {
+		p.mu.Lock()
+		p.modemState = decodeModemState(payload[1])
+		p.mu.Unlock()
+	}
+
+	return nil
+}
+
+// decodeModemState translates an RFC 2217 modem-state byte, which mirrors
+// the UART MSR register layout, into our ModemStatusBits.
+func decodeModemState(b byte) ModemStatusBits {
+	var result ModemStatusBits
+	if b&0x10 != 0 {
+		result |= STATUS_CTS
+	}
+	if b&0x20 != 0 {
+		result |= STATUS_DSR
+	}
+	if b&0x40 != 0 {
+		result |= STATUS_RI
+	}
+	if b&0x80 != 0 {
+		result |= STATUS_DCD
+	}
+	return result
+}
+
+func (p *rfc2217Port) Read(b []byte) (int, error) {
+	return p.in.Read(b)
+}
+
+func (p *rfc2217Port) Write(b []byte) (int, error) {
+	escaped := make([]byte, 0, len(b))
+	for _, c := range b {
+		if c == tnIAC {
+			escaped = append(escaped, tnIAC)
+		}
+		escaped = append(escaped, c)
+	}
+
+	if _, err := p.conn.Write(escaped); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (p *rfc2217Port) Close() error {
+	p.in.Close()
+	return p.conn.Close()
+}
+
+// SetDTR asserts or deasserts the DTR line via SET-CONTROL.
+func (p *rfc2217Port) SetDTR(enable bool) error {
+	if enable {
+		return p.sendSub(rfcSetControl, rfcControlDtrOn)
+	}
+	return p.sendSub(rfcSetControl, rfcControlDtrOff)
+}
+
+// SetRTS asserts or deasserts the RTS line via SET-CONTROL.
+func (p *rfc2217Port) SetRTS(enable bool) error {
+	if enable {
+		return p.sendSub(rfcSetControl, rfcControlRtsOn)
+	}
+	return p.sendSub(rfcSetControl, rfcControlRtsOff)
+}
+
+// GetModemStatus returns the most recent NOTIFY-MODEMSTATE reported by the
+// server.
+func (p *rfc2217Port) GetModemStatus() (ModemStatusBits, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.modemState, nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func TestSendSub(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	p := &rfc2217Port{conn: server}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- p.sendSub(rfcSetBaudRate, 0x00, tnIAC, 0x25, 0x80)
+	}()
+
+	buf := make([]byte, 9)
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatalf("reading subnegotiation: %v", err)
+	}
+
+	term := make([]byte, 2)
+	if _, err := io.ReadFull(client, term); err != nil {
+		t.Fatalf("reading terminator: %v", err)
+	}
+
+	if err := <-errCh; err != nil {
+		t.Fatalf("sendSub: %v", err)
+	}
+
+	expected := []byte{tnIAC, tnSB, comPortOption, rfcSetBaudRate, 0x00, tnIAC, tnIAC, 0x25, 0x80}
+	for i := range expected {
+		if buf[i] != expected[i] {
+			t.Fatalf("byte %d: got %#x, want %#x (buf=%#v)", i, buf[i], expected[i], buf)
+		}
+	}
+
+	if term[0] != tnIAC || term[1] != tnSE {
+		t.Errorf("got terminator %#v, want IAC SE", term)
+	}
+}
+
+func TestDemuxStripsTelnetCommands(t *testing.T) {
+	client, server := net.Pipe()
+	inR, inW := io.Pipe()
+	p := &rfc2217Port{conn: server, in: inR, inW: inW}
+
+	go p.demux()
+
+	go func() {
+		// Plain data, an escaped literal 0xFF, a WILL negotiation the client
+		// ignores, and more plain data.
+		client.Write([]byte{'h', 'i'})
+		client.Write([]byte{tnIAC, tnIAC})
+		client.Write([]byte{tnIAC, tnWILL, comPortOption})
+		client.Write([]byte{'!'})
+		client.Close()
+	}()
+
+	got, err := io.ReadAll(p.in)
+	if err != nil {
+		t.Fatalf("reading demuxed stream: %v", err)
+	}
+
+	want := []byte{'h', 'i', tnIAC, '!'}
+	if string(got) != string(want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestDemuxNotifyModemState(t *testing.T) {
+	client, server := net.Pipe()
+	inR, inW := io.Pipe()
+	p := &rfc2217Port{conn: server, in: inR, inW: inW}
+
+	go p.demux()
+
+	go func() {
+		client.Write([]byte{
+			tnIAC, tnSB, comPortOption, rfcNotifyModemState + 100, 0x30,
+			tnIAC, tnSE,
+		})
+		client.Close()
+	}()
+
+	if _, err := io.ReadAll(p.in); err != nil {
+		t.Fatalf("draining demuxed stream: %v", err)
+	}
+
+	status, err := p.GetModemStatus()
+	if err != nil {
+		t.Fatalf("GetModemStatus: %v", err)
+	}
+	if want := STATUS_CTS | STATUS_DSR; status != want {
+		t.Errorf("got modem status %#v, want %#v", status, want)
+	}
+}
+
+func TestDecodeModemState(t *testing.T) {
+	cases := []struct {
+		b    byte
+		want ModemStatusBits
+	}{
+		{0x00, 0},
+		{0x10, STATUS_CTS},
+		{0x20, STATUS_DSR},
+		{0x40, STATUS_RI},
+		{0x80, STATUS_DCD},
+		{0xf0, STATUS_CTS | STATUS_DSR | STATUS_RI | STATUS_DCD},
+	}
+
+	for _, c := range cases {
+		if got := decodeModemState(c.b); got != c.want {
+			t.Errorf("decodeModemState(%#x) = %#v, want %#v", c.b, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package serial provides a cross-platform interface to serial ports.
+package serial
+
+import (
+	"io"
+	"strings"
+	"time"
+)
+
+// ParityMode describes parity settings supported when opening a port.
+type ParityMode int
+
+const (
+	PARITY_NONE ParityMode = iota
+	PARITY_ODD
+	PARITY_EVEN
+)
+
+// FlowControl describes the flow control mode to use for a port.
+type FlowControl int
+
+const (
+	// No flow control.
+	FLOW_NONE FlowControl = iota
+
+	// Hardware (RTS/CTS) flow control.
+	FLOW_HARDWARE
+
+	// Software (XON/XOFF) flow control.
+	FLOW_SOFTWARE
+)
+
+// OpenOptions is the struct containing all of the options necessary for
+// opening a serial port.
+type OpenOptions struct {
+	// The name of the port, e.g. "/dev/tty.usbserial-A8008HlV".
+	PortName string
+
+	// The baud rate for the port.
+	BaudRate uint
+
+	// The number of data bits per frame. Legal values are 5, 6, 7, and 8.
+	DataBits uint
+
+	// The number of stop bits per frame. Legal values are 1 and 2.
+	StopBits uint
+
+	// The type of parity bits to use for the connection. Currently parity
+	// errors are simply ignored; that is, bytes are delivered to the user no
+	// matter whether they were received with a parity error or not.
+	ParityMode ParityMode
+
+	// The type of flow control to use for the connection. Defaults to
+	// FLOW_NONE if not set.
+	FlowControl FlowControl
+
+	// An inter-character timeout value, in milliseconds, and a minimum number
+	// of bytes to block for on each read. A call to Read() that otherwise
+	// might block waiting for more data will return early if the specified
+	// amount of time elapses between successive bytes received from the
+	// device or if the minimum number of bytes has been exceeded.
+	//
+	// On Windows the inter-character timeout is applied only when
+	// MinimumReadSize is 0, and MinimumReadSize itself is not applied.
+	InterCharacterTimeout uint
+	MinimumReadSize       uint
+}
+
+// Open creates an io.ReadWriteCloser based on the supplied options struct.
+//
+// PortName normally names a local tty device, e.g.
+// "/dev/tty.usbserial-A8008HlV" or "COM3". Two other schemes are recognized
+// and dispatched to a backend other than the local tty. PortName
+// "mock:<name>" connects to the in-memory port previously registered with
+// NewMockPort(<name>), for exercising protocol code in tests without real
+// hardware. PortName "rfc2217://host:port" dials a serial-over-IP device
+// server speaking the Telnet Com Port Control Option (RFC 2217).
+func Open(options OpenOptions) (io.ReadWriteCloser, error) {
+	switch {
+	case strings.HasPrefix(options.PortName, "mock:"):
+		return openMock(strings.TrimPrefix(options.PortName, "mock:"))
+
+	case strings.HasPrefix(options.PortName, "rfc2217://"):
+		return openRFC2217(options)
+
+	default:
+		return openInternal(options)
+	}
+}
+
+// ModemStatusBits is a bitmask of modem status lines as returned by
+// Port.GetModemStatus.
+type ModemStatusBits int
+
+const (
+	STATUS_DSR ModemStatusBits = 1 << iota
+	STATUS_CTS
+	STATUS_DCD
+	STATUS_RI
+)
+
+// Port is implemented by the values that Open returns on backends that
+// support direct control of the modem control lines (DTR, RTS) and
+// observation of the modem status lines (DSR, CTS, DCD, RI). Not every
+// backend implements it, so callers that need it should use a type
+// assertion:
+//
+//   if p, ok := rwc.(serial.Port); ok {
+//     p.SetDTR(true)
+//   }
+type Port interface {
+	io.ReadWriteCloser
+
+	// SetDTR asserts or deasserts the DTR (Data Terminal Ready) line.
+	SetDTR(enable bool) error
+
+	// SetRTS asserts or deasserts the RTS (Request To Send) line.
+	SetRTS(enable bool) error
+
+	// GetModemStatus returns the current state of the DSR, CTS, DCD and RI
+	// lines as a bitmask of ModemStatusBits.
+	GetModemStatus() (ModemStatusBits, error)
+}
+
+// Deadliner is implemented by the values Open returns for local ttys. On Linux
+// and OS X the fd is left in non-blocking mode and handed to the runtime's
+// poller, so deadlines take effect and a Close() called from another goroutine
+// while a Read or Write is outstanding unblocks it, same as with a net.Conn.
+// On Windows Open returns a plain *os.File over a handle opened without
+// overlapped I/O; it has these methods, but they are not backed by the
+// runtime's poller. The "mock:" and "rfc2217://" backends do not implement
+// Deadliner.
+//
+//   if d, ok := rwc.(serial.Deadliner); ok {
+//     d.SetReadDeadline(time.Now().Add(time.Second))
+//   }
+type Deadliner interface {
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// PortInfo describes one serial port discovered by ListPorts.
+type PortInfo struct {
+	// The name to pass as OpenOptions.PortName in order to open this port.
+	Name string
+
+	// A human-readable description of the device, if the platform exposes
+	// one.
+	Description string
+
+	// USB vendor ID, product ID, serial number and manufacturer string, as
+	// reported by the device. Empty when the port isn't USB-backed or the
+	// platform couldn't determine them.
+	VID          string
+	PID          string
+	SerialNumber string
+	Manufacturer string
+}
+
+// ListPorts enumerates the serial ports currently present on the system.
+func ListPorts() ([]PortInfo, error) {
+	return listPorts()
+}